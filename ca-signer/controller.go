@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// resyncPeriod controls both how often the Secret/Node informers fire a
+// synthetic Update (so expiry is re-checked even without a real change)
+// and therefore the finest granularity at which renewal is noticed.
+const resyncPeriod = 10 * time.Minute
+
+// Controller reconciles the output TLS Secret against the CertRequestSpec:
+// it reissues the leaf certificate when it is close to expiry, when the CA
+// has rotated, or when the set of controller-node SANs has changed.
+type Controller struct {
+	client kubernetes.Interface
+	spec   *CertRequestSpec
+
+	factory informers.SharedInformerFactory
+	// servicesFactory is unscoped (no WithNamespace) because
+	// spec.ServiceNames entries may reference a Service outside
+	// spec.Namespace via its "namespace/name" form.
+	servicesFactory informers.SharedInformerFactory
+}
+
+func NewController(client kubernetes.Interface, spec *CertRequestSpec) *Controller {
+	return &Controller{
+		client:          client,
+		spec:            spec,
+		factory:         informers.NewSharedInformerFactoryWithOptions(client, resyncPeriod, informers.WithNamespace(spec.Namespace)),
+		servicesFactory: informers.NewSharedInformerFactory(client, resyncPeriod),
+	}
+}
+
+// Run starts the Secret/Node informers and blocks until ctx is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	secrets := c.factory.Core().V1().Secrets().Informer()
+	nodes := c.factory.Core().V1().Nodes().Informer()
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { c.onSecretEvent(ctx, obj) },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.onSecretEvent(ctx, newObj)
+		},
+	}
+	if _, err := secrets.AddEventHandler(handler); err != nil {
+		return fmt.Errorf("failed to register secret handler: %w", err)
+	}
+
+	nodeHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if node, ok := obj.(*corev1.Node); ok && c.relevantNode(node) {
+				c.reconcile(ctx, "node-added")
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) { c.onNodeEvent(oldObj, newObj, ctx) },
+	}
+	if _, err := nodes.AddEventHandler(nodeHandler); err != nil {
+		return fmt.Errorf("failed to register node handler: %w", err)
+	}
+
+	var services cache.SharedIndexInformer
+	if len(c.spec.ServiceNames) > 0 {
+		services = c.servicesFactory.Core().V1().Services().Informer()
+		serviceHandler := cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if svc, ok := obj.(*corev1.Service); ok && c.relevantService(svc) {
+					c.reconcile(ctx, "service-added")
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) { c.onServiceEvent(oldObj, newObj, ctx) },
+		}
+		if _, err := services.AddEventHandler(serviceHandler); err != nil {
+			return fmt.Errorf("failed to register service handler: %w", err)
+		}
+	}
+
+	c.factory.Start(ctx.Done())
+	c.factory.WaitForCacheSync(ctx.Done())
+	if services != nil {
+		c.servicesFactory.Start(ctx.Done())
+		c.servicesFactory.WaitForCacheSync(ctx.Done())
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) onSecretEvent(ctx context.Context, obj interface{}) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok {
+		return
+	}
+	switch secret.Name {
+	case c.spec.CASecretName:
+		c.reconcile(ctx, "ca-rotated")
+	case c.spec.OutputSecret:
+		c.reconcile(ctx, "renewal-check")
+	}
+}
+
+func (c *Controller) onNodeEvent(oldObj, newObj interface{}, ctx context.Context) {
+	oldNode, ok1 := oldObj.(*corev1.Node)
+	newNode, ok2 := newObj.(*corev1.Node)
+	if !ok1 || !ok2 {
+		return
+	}
+	if !c.relevantNode(oldNode) && !c.relevantNode(newNode) {
+		return
+	}
+	if !reflect.DeepEqual(nodeAddresses(oldNode, c.spec.IncludeExternalIP), nodeAddresses(newNode, c.spec.IncludeExternalIP)) {
+		c.reconcile(ctx, "node-ip-changed")
+	}
+}
+
+// relevantNode reports whether a Node is one this controller draws
+// SANs from: either the single named CONTROLLER_NODE_NAME, or any node
+// matching CONTROLLER_NODE_SELECTOR.
+func (c *Controller) relevantNode(node *corev1.Node) bool {
+	if c.spec.ControllerNodeSelector != "" {
+		selector, err := labels.Parse(c.spec.ControllerNodeSelector)
+		if err != nil {
+			return false
+		}
+		return selector.Matches(labels.Set(node.Labels))
+	}
+	return node.Name == c.spec.ControllerNode
+}
+
+func internalIPs(node *corev1.Node) []string {
+	var ips []string
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeInternalIP {
+			ips = append(ips, addr.Address)
+		}
+	}
+	sort.Strings(ips)
+	return ips
+}
+
+// nodeAddresses returns a node's internal IPs, plus its external IPs
+// too when includeExternal is set (CONTROLLER_NODE_EXTERNAL_IP=true).
+func nodeAddresses(node *corev1.Node, includeExternal bool) []string {
+	ips := internalIPs(node)
+	if !includeExternal {
+		return ips
+	}
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeExternalIP {
+			ips = append(ips, addr.Address)
+		}
+	}
+	return ips
+}
+
+// relevantService reports whether svc is one of spec.ServiceNames
+// ("name", defaulting to spec.Namespace, or "namespace/name").
+func (c *Controller) relevantService(svc *corev1.Service) bool {
+	for _, ref := range c.spec.ServiceNames {
+		ns, name := c.spec.Namespace, ref
+		if parts := strings.SplitN(ref, "/", 2); len(parts) == 2 {
+			ns, name = parts[0], parts[1]
+		}
+		if svc.Namespace == ns && svc.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Controller) onServiceEvent(oldObj, newObj interface{}, ctx context.Context) {
+	oldSvc, ok1 := oldObj.(*corev1.Service)
+	newSvc, ok2 := newObj.(*corev1.Service)
+	if !ok1 || !ok2 {
+		return
+	}
+	if !c.relevantService(oldSvc) && !c.relevantService(newSvc) {
+		return
+	}
+	if !reflect.DeepEqual(serviceAddresses(oldSvc), serviceAddresses(newSvc)) {
+		c.reconcile(ctx, "service-ip-changed")
+	}
+}
+
+// serviceAddresses returns svc's ClusterIP plus any LoadBalancer
+// ingress IPs/hostnames -- the same fields serviceSANs reads in
+// sans.go -- so onServiceEvent can tell whether a Service update
+// actually changed the SANs it contributes.
+func serviceAddresses(svc *corev1.Service) []string {
+	var addrs []string
+	if svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != corev1.ClusterIPNone {
+		addrs = append(addrs, svc.Spec.ClusterIP)
+	}
+	for _, ing := range svc.Status.LoadBalancer.Ingress {
+		if ing.IP != "" {
+			addrs = append(addrs, ing.IP)
+		}
+		if ing.Hostname != "" {
+			addrs = append(addrs, ing.Hostname)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// reconcile decides whether the leaf certificate needs to be (re)issued
+// and, if so, does it. `reason` is only used for logging/metrics when a
+// reissue actually happens; the decision itself is made fresh every time
+// based on the live state of the CA secret, the output secret and the
+// controller node.
+func (c *Controller) reconcile(ctx context.Context, trigger string) {
+	log.Printf("reconcile triggered by %s", trigger)
+	extraIPs, extraDNS, err := c.collectSANs(ctx)
+	if err != nil {
+		log.Printf("reconcile: failed to collect SANs: %v", err)
+	}
+
+	caSecret, err := c.client.CoreV1().Secrets(c.spec.Namespace).Get(ctx, c.spec.CASecretName, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("reconcile: failed to get CA secret %s: %v", c.spec.CASecretName, err)
+		return
+	}
+	caCertPEM := caSecret.Data["ca.crt"]
+	caKeyPEM := caSecret.Data["ca.key"]
+	if caCertPEM == nil || caKeyPEM == nil {
+		log.Printf("reconcile: ca.crt or ca.key missing in CA secret %s", c.spec.CASecretName)
+		return
+	}
+
+	outSecret, err := c.client.CoreV1().Secrets(c.spec.Namespace).Get(ctx, c.spec.OutputSecret, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("output secret %s not found, issuing: %v", c.spec.OutputSecret, err)
+		c.issueCert(ctx, "missing", caCertPEM, caKeyPEM, extraIPs, extraDNS)
+		return
+	}
+
+	actualReason, needsReissue := c.needsReissue(outSecret, caCertPEM, extraIPs, extraDNS)
+	if !needsReissue {
+		if leafCert, err := parseLeafCert(outSecret.Data["tls.crt"]); err == nil {
+			certExpirySeconds.Set(float64(leafCert.NotAfter.Unix()))
+		}
+		return
+	}
+	c.issueCert(ctx, actualReason, caCertPEM, caKeyPEM, extraIPs, extraDNS)
+}
+
+func (c *Controller) needsReissue(outSecret *corev1.Secret, caCertPEM []byte, extraIPs, extraDNS []string) (string, bool) {
+	leafCert, err := parseLeafCert(outSecret.Data["tls.crt"])
+	if err != nil {
+		return "corrupt", true
+	}
+	if !bytes.Equal(outSecret.Data["ca.crt"], caCertPEM) {
+		return "ca-rotated", true
+	}
+	if time.Now().After(leafCert.NotAfter.Add(-c.spec.RenewBefore)) {
+		return "renewal", true
+	}
+	if !sameSANs(leafCert, extraIPs, extraDNS, c.spec.SANs) {
+		return "sans-changed", true
+	}
+	return "", false
+}
+
+func parseLeafCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode tls.crt")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func sameSANs(cert *x509.Certificate, extraIPs, extraDNS []string, sans string) bool {
+	wantIPs := append([]string{}, extraIPs...)
+	wantDNS := append([]string{}, extraDNS...)
+	for _, san := range splitSANs(sans) {
+		switch san.typ {
+		case "DNS":
+			wantDNS = append(wantDNS, san.value)
+		case "IP":
+			wantIPs = append(wantIPs, san.value)
+		}
+	}
+	sort.Strings(wantDNS)
+	sort.Strings(wantIPs)
+
+	var gotIPs []string
+	for _, ip := range cert.IPAddresses {
+		gotIPs = append(gotIPs, ip.String())
+	}
+	gotDNS := append([]string{}, cert.DNSNames...)
+	sort.Strings(gotDNS)
+	sort.Strings(gotIPs)
+
+	return reflect.DeepEqual(wantDNS, gotDNS) && reflect.DeepEqual(wantIPs, gotIPs)
+}
+
+// issueCert mints a new leaf certificate signed by the CA and writes it
+// (plus the CA cert, for trust chains) into the output Secret.
+func (c *Controller) issueCert(ctx context.Context, reason string, caCertPEM, caKeyPEM []byte, extraIPs, extraDNS []string) {
+	log.Printf("issuing certificate for %s/%s (reason=%s)", c.spec.Namespace, c.spec.OutputSecret, reason)
+
+	caCertBlock, _ := pem.Decode(caCertPEM)
+	if caCertBlock == nil {
+		log.Printf("issueCert: failed to decode ca.crt")
+		return
+	}
+	caCert, err := x509.ParseCertificate(caCertBlock.Bytes)
+	if err != nil {
+		log.Printf("issueCert: failed to parse ca.crt: %v", err)
+		return
+	}
+
+	caKeyBlock, _ := pem.Decode(caKeyPEM)
+	if caKeyBlock == nil {
+		log.Printf("issueCert: failed to decode ca.key")
+		return
+	}
+	caKey, err := x509.ParsePKCS1PrivateKey(caKeyBlock.Bytes)
+	if err != nil {
+		log.Printf("issueCert: failed to parse ca.key: %v", err)
+		return
+	}
+
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Printf("issueCert: failed to generate key: %v", err)
+		return
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, big.NewInt(0).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		log.Printf("issueCert: failed to generate serial: %v", err)
+		return
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName: c.spec.CommonName,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	if len(extraIPs) > 0 {
+		log.Printf("Adding auto-discovered IPs as SANs: %v", extraIPs)
+		for _, ip := range extraIPs {
+			template.IPAddresses = append(template.IPAddresses, net.ParseIP(ip))
+		}
+	}
+	if len(extraDNS) > 0 {
+		log.Printf("Adding auto-discovered DNS names as SANs: %v", extraDNS)
+		template.DNSNames = append(template.DNSNames, extraDNS...)
+	}
+
+	if c.spec.SANs != "" {
+		for _, san := range splitSANs(c.spec.SANs) {
+			switch san.typ {
+			case "DNS":
+				template.DNSNames = append(template.DNSNames, san.value)
+			case "IP":
+				if ip := net.ParseIP(san.value); ip != nil {
+					template.IPAddresses = append(template.IPAddresses, ip)
+				}
+			}
+		}
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, privKey.Public(), caKey)
+	if err != nil {
+		log.Printf("issueCert: failed to create certificate: %v", err)
+		return
+	}
+
+	privBytes, err := x509.MarshalECPrivateKey(privKey)
+	if err != nil {
+		log.Printf("issueCert: failed to marshal key: %v", err)
+		return
+	}
+
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes})
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: c.spec.OutputSecret, Namespace: c.spec.Namespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.key": privPEM,
+			"tls.crt": certPEM,
+			"ca.crt":  caCertPEM,
+		},
+	}
+
+	if _, err := c.client.CoreV1().Secrets(c.spec.Namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		if _, err := c.client.CoreV1().Secrets(c.spec.Namespace).Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			log.Printf("issueCert: failed to create/update secret: %v", err)
+			return
+		}
+	}
+
+	certReissueTotal.WithLabelValues(reason).Inc()
+	certExpirySeconds.Set(float64(template.NotAfter.Unix()))
+	log.Printf("Generated key and cert with SANs stored in secret %s/%s", c.spec.Namespace, c.spec.OutputSecret)
+}
+
+func (c *Controller) getControllerNodeIPs(ctx context.Context) ([]string, error) {
+	if c.spec.ControllerNodeSelector != "" {
+		nodeList, err := c.client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: c.spec.ControllerNodeSelector})
+		if err != nil {
+			return nil, err
+		}
+		var ips []string
+		for _, node := range nodeList.Items {
+			ips = append(ips, nodeAddresses(&node, c.spec.IncludeExternalIP)...)
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("no nodes matched selector %q", c.spec.ControllerNodeSelector)
+		}
+		return dedupe(ips), nil
+	}
+
+	nodeName := c.spec.ControllerNode
+	node, err := c.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	controllerIP := nodeAddresses(node, c.spec.IncludeExternalIP)
+	if len(controllerIP) == 0 {
+		return nil, fmt.Errorf("no internal IP found for node %s", nodeName)
+	}
+	return controllerIP, nil
+}
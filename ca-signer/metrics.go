@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	certExpirySeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "skycluster_cert_expiry_seconds",
+		Help: "Unix time (seconds) at which the currently issued leaf certificate expires.",
+	})
+
+	certReissueTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "skycluster_cert_reissue_total",
+		Help: "Total number of leaf certificate (re)issuances, labeled by reason.",
+	}, []string{"reason"})
+)
+
+// serveMetrics starts the Prometheus /metrics endpoint in the background.
+// It never returns; call it in a goroutine.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Printf("serving metrics on %s/metrics", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Fatalf("metrics server failed: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// collectSANs gathers every automatic SAN source beyond CERT_SANS:
+// the controller node(s), this pod's own IP(s) via the downward API,
+// and the named Services' ClusterIPs/LoadBalancer ingress. Callers
+// merge the result with splitSANs(spec.SANs).
+func (c *Controller) collectSANs(ctx context.Context) (ips []string, dns []string, err error) {
+	nodeIPs, err := c.getControllerNodeIPs(ctx)
+	if err != nil {
+		log.Printf("collectSANs: failed to get controller node IPs: %v", err)
+	}
+	ips = append(ips, nodeIPs...)
+	ips = append(ips, podIPsFromDownwardAPI()...)
+
+	svcIPs, svcDNS, err := c.serviceSANs(ctx)
+	if err != nil {
+		log.Printf("collectSANs: failed to resolve SERVICE_NAMES: %v", err)
+	}
+	ips = append(ips, svcIPs...)
+	dns = append(dns, svcDNS...)
+
+	return dedupe(ips), dedupe(dns), nil
+}
+
+// podIPsFromDownwardAPI reads this pod's own IP(s) so every replica of
+// a multi-replica Deployment automatically inserts itself as a SAN.
+// POD_IP (downward API status.podIP, single address) and POD_IPS
+// (status.podIPs, newline- or comma-separated, for dual-stack) are both
+// honored.
+func podIPsFromDownwardAPI() []string {
+	var ips []string
+	if v := os.Getenv("POD_IP"); v != "" {
+		ips = append(ips, v)
+	}
+	if v := os.Getenv("POD_IPS"); v != "" {
+		for _, ip := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == '\n' }) {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// serviceSANs resolves spec.ServiceNames ("name" or "namespace/name",
+// defaulting to spec.Namespace) to ClusterIPs and LoadBalancer ingress
+// IPs/hostnames.
+func (c *Controller) serviceSANs(ctx context.Context) (ips []string, dns []string, err error) {
+	for _, ref := range c.spec.ServiceNames {
+		ns, name := c.spec.Namespace, ref
+		if parts := strings.SplitN(ref, "/", 2); len(parts) == 2 {
+			ns, name = parts[0], parts[1]
+		}
+
+		svc, getErr := c.client.CoreV1().Services(ns).Get(ctx, name, metav1.GetOptions{})
+		if getErr != nil {
+			err = fmt.Errorf("service %s/%s: %w", ns, name, getErr)
+			continue
+		}
+		if svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != corev1.ClusterIPNone {
+			ips = append(ips, svc.Spec.ClusterIP)
+		}
+		for _, ing := range svc.Status.LoadBalancer.Ingress {
+			if ing.IP != "" {
+				ips = append(ips, ing.IP)
+			}
+			if ing.Hostname != "" {
+				dns = append(dns, ing.Hostname)
+			}
+		}
+	}
+	return ips, dns, err
+}
+
+func dedupe(vals []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, v := range vals {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
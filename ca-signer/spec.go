@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// CertRequestSpec captures everything needed to mint (or re-mint) the
+// leaf certificate. It started life as a handful of env-var lookups in
+// main(); it is now read once at startup and reused by the reconciler
+// on every reissue so the renewal loop and the original one-shot path
+// stay in sync.
+type CertRequestSpec struct {
+	CASecretName   string
+	OutputSecret   string
+	Namespace      string
+	CommonName     string
+	SANs           string
+	ControllerNode string
+
+	// ControllerNodeSelector, if set, replaces the single-node lookup
+	// (ControllerNode) with a label-selector match across every
+	// schedulable node whose internal (and, if enabled, external) IPs
+	// are unioned into the SAN set.
+	ControllerNodeSelector string
+	IncludeExternalIP      bool
+
+	// ServiceNames resolves to the ClusterIPs and LoadBalancer
+	// ingress IPs/hostnames of the named Services, added as SANs.
+	ServiceNames []string
+
+	// RenewBefore is how long before NotAfter the reconciler reissues
+	// the leaf certificate. Defaults to 30 days.
+	RenewBefore time.Duration
+}
+
+const defaultRenewBefore = 30 * 24 * time.Hour
+
+func specFromEnv() (*CertRequestSpec, error) {
+	spec := &CertRequestSpec{
+		CASecretName:           os.Getenv("CA_SECRET_NAME"),
+		OutputSecret:           os.Getenv("OUTPUT_SECRET_NAME"),
+		Namespace:              os.Getenv("NAMESPACE"),
+		CommonName:             os.Getenv("CERT_COMMON_NAME"),
+		SANs:                   os.Getenv("CERT_SANS"),
+		ControllerNode:         os.Getenv("CONTROLLER_NODE_NAME"),
+		ControllerNodeSelector: os.Getenv("CONTROLLER_NODE_SELECTOR"),
+		IncludeExternalIP:      os.Getenv("CONTROLLER_NODE_EXTERNAL_IP") == "true",
+		RenewBefore:            defaultRenewBefore,
+	}
+	if v := os.Getenv("SERVICE_NAMES"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				spec.ServiceNames = append(spec.ServiceNames, name)
+			}
+		}
+	}
+	if spec.ControllerNode == "" && spec.ControllerNodeSelector == "" {
+		spec.ControllerNode = "skycluster-control-plane" // Default node name if not set
+	}
+	if v := os.Getenv("CERT_RENEW_BEFORE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CERT_RENEW_BEFORE %q: %w", v, err)
+		}
+		spec.RenewBefore = d
+	}
+
+	if spec.CASecretName == "" || spec.OutputSecret == "" || spec.Namespace == "" || spec.CommonName == "" {
+		return nil, fmt.Errorf("CA_SECRET_NAME, OUTPUT_SECRET_NAME, NAMESPACE, CERT_COMMON_NAME required")
+	}
+	return spec, nil
+}
+
+type sanEntry struct {
+	typ   string
+	value string
+}
+
+// splitSANs parses the comma-separated "TYPE:value" entries of
+// CERT_SANS (e.g. "DNS:example.com,IP:10.0.0.1") into sanEntry values,
+// normalizing typ to uppercase so "dns"/"DNS"/"Dns" are all accepted,
+// and silently dropping entries that have no ":" or an empty typ/value.
+func splitSANs(sans string) []sanEntry {
+	var res []sanEntry
+	for _, part := range strings.Split(sans, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		colon := strings.Index(part, ":")
+		if colon < 1 || colon == len(part)-1 {
+			continue
+		}
+		typ := strings.ToUpper(strings.TrimSpace(part[:colon]))
+		value := strings.TrimSpace(part[colon+1:])
+		if typ == "" || value == "" {
+			continue
+		}
+		res = append(res, sanEntry{typ: typ, value: value})
+	}
+	return res
+}
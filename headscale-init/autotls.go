@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AutoTLSConfig controls the AUTO_TLS=true fallback: when the TLS
+// Secret referenced by SECRET_NAME is missing or incomplete, generate
+// a self-signed CA and server cert for it instead of fataling.
+type AutoTLSConfig struct {
+	Enabled bool
+
+	CACommonName   string
+	CAOrganization string
+	CAValidity     time.Duration
+
+	ServerCommonName string
+	ExtraSANs        []string
+
+	RenewBefore time.Duration
+}
+
+func autoTLSConfigFromEnv() (*AutoTLSConfig, error) {
+	cfg := &AutoTLSConfig{
+		Enabled:        os.Getenv("AUTO_TLS") == "true",
+		CACommonName:   envOrDefault("TLS_CA_COMMON_NAME", "skycluster-headscale-ca"),
+		CAOrganization: envOrDefault("TLS_CA_ORGANIZATION", "SkyCluster"),
+		CAValidity:     8760 * time.Hour,
+		RenewBefore:    720 * time.Hour,
+	}
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+
+	if v := os.Getenv("TLS_CA_VALIDITY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS_CA_VALIDITY: %w", err)
+		}
+		cfg.CAValidity = d
+	}
+	if v := os.Getenv("TLS_RENEW_BEFORE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS_RENEW_BEFORE: %w", err)
+		}
+		cfg.RenewBefore = d
+	}
+
+	serverURL := os.Getenv("HEADSCALE_SERVER_URL")
+	if serverURL == "" {
+		return nil, fmt.Errorf("HEADSCALE_SERVER_URL not set")
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HEADSCALE_SERVER_URL: %w", err)
+	}
+	cfg.ServerCommonName = u.Hostname()
+
+	if v := os.Getenv("TLS_EXTRA_SANS"); v != "" {
+		for _, san := range strings.Split(v, ",") {
+			if san = strings.TrimSpace(san); san != "" {
+				cfg.ExtraSANs = append(cfg.ExtraSANs, san)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// sanIPsAndDNS splits cfg's server CN and extra SANs into IP/DNS
+// buckets the way x509.Certificate wants them.
+func (cfg *AutoTLSConfig) sanIPsAndDNS() (ips []net.IP, dns []string) {
+	for _, san := range append([]string{cfg.ServerCommonName}, cfg.ExtraSANs...) {
+		if ip := net.ParseIP(san); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dns = append(dns, san)
+		}
+	}
+	return ips, dns
+}
+
+// EnsureTLSSecret returns secretName in namespace unmodified when it
+// already holds a valid, not-soon-expiring tls.crt/tls.key/ca.crt --
+// regardless of whether ca.key is present, since plenty of real
+// sources (cert-manager, a hand-supplied cert) never ship a CA private
+// key alongside the cert. Only when one of those three keys is
+// missing, unparseable, or close to expiry does it generate (or
+// reuse, if ca.key is present and still valid) a self-signed CA, issue
+// a fresh server cert, and write the result back to the Secret.
+func EnsureTLSSecret(ctx context.Context, client kubernetes.Interface, namespace, secretName string, cfg *AutoTLSConfig) (*corev1.Secret, error) {
+	secretsClient := client.CoreV1().Secrets(namespace)
+
+	secret, err := secretsClient.Get(ctx, secretName, metav1.GetOptions{})
+	notFound := errors.IsNotFound(err)
+	if err != nil && !notFound {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", namespace, secretName, err)
+	}
+	if notFound {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: namespace},
+			Type:       corev1.SecretTypeTLS,
+			Data:       map[string][]byte{},
+		}
+	} else if hasValidTLSMaterial(secret, cfg) {
+		return secret, nil
+	}
+
+	ips, dns := cfg.sanIPsAndDNS()
+
+	caCert, caKey, caReused, err := loadOrGenerateCA(secret, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if caReused {
+		if leaf, err := parseAutoTLSCert(secret.Data["tls.crt"]); err == nil {
+			if time.Now().Before(leaf.NotAfter.Add(-cfg.RenewBefore)) && sameAutoTLSSANs(leaf, ips, dns) {
+				return secret, nil
+			}
+		}
+	}
+
+	leafCertPEM, leafKeyPEM, leaf, err := issueServerCert(caCert, caKey, cfg, ips, dns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue server cert: %w", err)
+	}
+	log.Printf("AUTO_TLS: issuing server cert for %s/%s, expires %s", namespace, secretName, leaf.NotAfter)
+
+	caCertPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})
+	caKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(caKey)})
+
+	secret.Data = map[string][]byte{
+		"tls.crt": leafCertPEM,
+		"tls.key": leafKeyPEM,
+		"ca.crt":  caCertPEM,
+		"ca.key":  caKeyPEM,
+	}
+
+	if notFound {
+		secret, err = secretsClient.Create(ctx, secret, metav1.CreateOptions{})
+	} else {
+		secret, err = secretsClient.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to persist auto-generated TLS secret %s/%s: %w", namespace, secretName, err)
+	}
+	return secret, nil
+}
+
+// loadOrGenerateCA reuses the CA in secret's ca.crt/ca.key when both
+// are present and parse cleanly, so server-cert renewals don't
+// invalidate certs clients have already pinned the CA for. Otherwise
+// it mints a fresh self-signed CA.
+func loadOrGenerateCA(secret *corev1.Secret, cfg *AutoTLSConfig) (cert *x509.Certificate, key *rsa.PrivateKey, reused bool, err error) {
+	certPEM, keyPEM := secret.Data["ca.crt"], secret.Data["ca.key"]
+	if certPEM != nil && keyPEM != nil {
+		cert, key, err := parseCA(certPEM, keyPEM)
+		if err == nil && time.Now().Before(cert.NotAfter.Add(-cfg.RenewBefore)) {
+			return cert, key, true, nil
+		}
+	}
+	cert, key, err = generateCA(cfg)
+	return cert, key, false, err
+}
+
+func parseCA(certPEM, keyPEM []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	cert, err := parseAutoTLSCert(certPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("failed to decode ca.key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse ca.key: %w", err)
+	}
+	return cert, key, nil
+}
+
+func generateCA(cfg *AutoTLSConfig) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   cfg.CACommonName,
+			Organization: []string{cfg.CAOrganization},
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(cfg.CAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func issueServerCert(caCert *x509.Certificate, caKey *rsa.PrivateKey, cfg *AutoTLSConfig, ips []net.IP, dns []string) (certPEM, keyPEM []byte, leaf *x509.Certificate, err error) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate server key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	renewWindow := cfg.RenewBefore
+	if renewWindow <= 0 {
+		renewWindow = 720 * time.Hour
+	}
+	validity := 2 * renewWindow
+	if validity < 24*time.Hour {
+		validity = 24 * time.Hour
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cfg.ServerCommonName},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IPAddresses:           ips,
+		DNSNames:              dns,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, &privKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create server certificate: %w", err)
+	}
+	leaf, err = x509.ParseCertificate(derBytes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	privBytes, err := x509.MarshalECPrivateKey(privKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to marshal server key: %w", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes})
+	return certPEM, keyPEM, leaf, nil
+}
+
+// hasValidTLSMaterial reports whether secret already has a complete,
+// parseable, not-soon-expiring tls.crt/tls.key/ca.crt -- independent
+// of ca.key, which is only needed to reuse or rotate a CA we minted
+// ourselves, not to judge whether existing serving material is usable.
+func hasValidTLSMaterial(secret *corev1.Secret, cfg *AutoTLSConfig) bool {
+	certPEM, keyPEM, caCertPEM := secret.Data["tls.crt"], secret.Data["tls.key"], secret.Data["ca.crt"]
+	if certPEM == nil || keyPEM == nil || caCertPEM == nil {
+		return false
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return false
+	}
+	leaf, err := parseAutoTLSCert(certPEM)
+	if err != nil {
+		return false
+	}
+	if _, err := parseAutoTLSCert(caCertPEM); err != nil {
+		return false
+	}
+	return time.Now().Before(leaf.NotAfter.Add(-cfg.RenewBefore))
+}
+
+func parseAutoTLSCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func sameAutoTLSSANs(cert *x509.Certificate, ips []net.IP, dns []string) bool {
+	wantIPs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		wantIPs = append(wantIPs, ip.String())
+	}
+	wantDNS := append([]string{}, dns...)
+	sort.Strings(wantIPs)
+	sort.Strings(wantDNS)
+
+	var gotIPs []string
+	for _, ip := range cert.IPAddresses {
+		gotIPs = append(gotIPs, ip.String())
+	}
+	gotDNS := append([]string{}, cert.DNSNames...)
+	sort.Strings(gotIPs)
+	sort.Strings(gotDNS)
+
+	return reflect.DeepEqual(wantIPs, gotIPs) && reflect.DeepEqual(wantDNS, gotDNS)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Controller watches the TLS Secret (and, optionally, the ConfigMap
+// holding the ACL) and re-renders + reloads Headscale whenever either
+// changes, instead of rendering once and exiting.
+type Controller struct {
+	client kubernetes.Interface
+
+	secretNamespace, secretName string
+	aclCMNamespace, aclCMName   string
+
+	bootstrap *Bootstrap
+	reloader  *Reloader
+	autoTLS   *AutoTLSConfig
+
+	ready atomic.Bool
+}
+
+func NewController(client kubernetes.Interface, secretNamespace, secretName, aclCMNamespace, aclCMName string, bootstrap *Bootstrap, reloader *Reloader, autoTLS *AutoTLSConfig) *Controller {
+	return &Controller{
+		client:          client,
+		secretNamespace: secretNamespace,
+		secretName:      secretName,
+		aclCMNamespace:  aclCMNamespace,
+		aclCMName:       aclCMName,
+		bootstrap:       bootstrap,
+		reloader:        reloader,
+		autoTLS:         autoTLS,
+	}
+}
+
+// Ready reports whether the first render has succeeded, for /readyz.
+func (c *Controller) Ready() bool { return c.ready.Load() }
+
+// Run watches the relevant Secret/ConfigMap and blocks until ctx is
+// cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(c.client, 10*time.Minute, informers.WithNamespace(c.secretNamespace))
+	secrets := factory.Core().V1().Secrets().Informer()
+
+	var cmFactory informers.SharedInformerFactory
+	var configMaps cache.SharedIndexInformer
+	if c.aclCMName != "" {
+		cmFactory = informers.NewSharedInformerFactoryWithOptions(c.client, 10*time.Minute, informers.WithNamespace(c.aclCMNamespace))
+		configMaps = cmFactory.Core().V1().ConfigMaps().Informer()
+	}
+
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.onChange(ctx, obj) },
+		UpdateFunc: func(_, newObj interface{}) { c.onChange(ctx, newObj) },
+	}
+	if _, err := secrets.AddEventHandler(handler); err != nil {
+		return fmt.Errorf("failed to register secret handler: %w", err)
+	}
+	if configMaps != nil {
+		if _, err := configMaps.AddEventHandler(handler); err != nil {
+			return fmt.Errorf("failed to register acl configmap handler: %w", err)
+		}
+	}
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	if cmFactory != nil {
+		cmFactory.Start(ctx.Done())
+		cmFactory.WaitForCacheSync(ctx.Done())
+	}
+
+	// Render once up front: with AUTO_TLS enabled the Secret usually
+	// doesn't exist yet, and a Secret that's never created never fires
+	// an informer Add, so waiting on events alone would never bootstrap.
+	c.renderAndReload(ctx)
+
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) onChange(ctx context.Context, obj interface{}) {
+	switch v := obj.(type) {
+	case *corev1.Secret:
+		if v.Name != c.secretName {
+			return
+		}
+	case *corev1.ConfigMap:
+		if v.Name != c.aclCMName {
+			return
+		}
+	default:
+		return
+	}
+	c.renderAndReload(ctx)
+}
+
+func (c *Controller) renderAndReload(ctx context.Context) {
+	var secret *corev1.Secret
+	var err error
+	if c.autoTLS.Enabled {
+		secret, err = EnsureTLSSecret(ctx, c.client, c.secretNamespace, c.secretName, c.autoTLS)
+		if err != nil {
+			log.Printf("AUTO_TLS: failed to ensure secret %s/%s: %v", c.secretNamespace, c.secretName, err)
+			return
+		}
+	} else {
+		secret, err = c.client.CoreV1().Secrets(c.secretNamespace).Get(ctx, c.secretName, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("failed to get secret %s/%s: %v", c.secretNamespace, c.secretName, err)
+			return
+		}
+	}
+
+	var aclOverride []byte
+	if c.aclCMName != "" {
+		cm, err := c.client.CoreV1().ConfigMaps(c.aclCMNamespace).Get(ctx, c.aclCMName, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("failed to get acl configmap %s/%s: %v", c.aclCMNamespace, c.aclCMName, err)
+			return
+		}
+		data, ok := cm.Data["acl.json"]
+		if !ok {
+			log.Printf("acl configmap %s/%s missing acl.json key", c.aclCMNamespace, c.aclCMName)
+			return
+		}
+		aclOverride = []byte(data)
+	}
+
+	if err := c.bootstrap.render(ctx, secret, aclOverride); err != nil {
+		log.Printf("failed to render config: %v", err)
+		return
+	}
+	c.ready.Store(true)
+
+	if err := c.reloader.Reload(); err != nil {
+		log.Printf("failed to reload headscale: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Reloader signals Headscale to pick up freshly rewritten config/cert
+// files, either by sending it SIGHUP or by running an operator-supplied
+// hook command.
+type Reloader struct {
+	PIDFile string
+	PID     int
+	HookCmd string
+}
+
+func reloaderFromEnv() *Reloader {
+	r := &Reloader{
+		PIDFile: os.Getenv("RELOAD_PID_FILE"),
+		HookCmd: os.Getenv("RELOAD_HOOK_CMD"),
+	}
+	if v := os.Getenv("RELOAD_PID"); v != "" {
+		if pid, err := strconv.Atoi(v); err == nil {
+			r.PID = pid
+		}
+	}
+	return r
+}
+
+// Reload runs the hook command if one is configured, otherwise sends
+// SIGHUP to the configured PID / pidfile.
+func (r *Reloader) Reload() error {
+	if r.HookCmd != "" {
+		return r.runHook()
+	}
+	return r.sighup()
+}
+
+func (r *Reloader) runHook() error {
+	fields := strings.Fields(r.HookCmd)
+	if len(fields) == 0 {
+		return fmt.Errorf("RELOAD_HOOK_CMD is empty")
+	}
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("reload hook %q failed: %w", r.HookCmd, err)
+	}
+	log.Printf("ran reload hook %q", r.HookCmd)
+	return nil
+}
+
+func (r *Reloader) sighup() error {
+	pid := r.PID
+	if pid == 0 && r.PIDFile != "" {
+		data, err := os.ReadFile(r.PIDFile)
+		if err != nil {
+			return fmt.Errorf("failed to read pidfile %s: %w", r.PIDFile, err)
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("invalid pid in %s: %w", r.PIDFile, err)
+		}
+	}
+	if pid == 0 {
+		return fmt.Errorf("no RELOAD_PID, RELOAD_PID_FILE or RELOAD_HOOK_CMD configured, skipping reload")
+	}
+
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("failed to find process %d: %w", pid, err)
+	}
+	if err := proc.Signal(syscall.SIGHUP); err != nil {
+		return fmt.Errorf("failed to send SIGHUP to pid %d: %w", pid, err)
+	}
+	log.Printf("sent SIGHUP to pid %d", pid)
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Bootstrap holds everything needed to render the Headscale config.yml
+// and acl.json from their source templates/ConfigMaps plus the live
+// TLS Secret.
+type Bootstrap struct {
+	ConfigTemplatePaths []string
+	ConfigConfigMaps    []ConfigMapRef
+	ACLPaths            []string
+	ACLConfigMaps       []ConfigMapRef
+	OutputDir           string
+
+	Client   kubernetes.Interface
+	Renderer *TemplateRenderer
+}
+
+// render renders config.yml and acl.json from their (possibly
+// layered) sources, folds in the TLS material from secret, and
+// atomically writes config.yml/acl.json/tls.* into OutputDir.
+// aclOverride, when non-nil, is merged on top of ACLPaths/ACLConfigMaps
+// -- it comes from the optional ACL ConfigMap the Controller watches
+// directly, so cluster-side ACL edits take precedence over the files
+// baked into the image.
+func (b *Bootstrap) render(ctx context.Context, secret *corev1.Secret, aclOverride []byte) error {
+	certData, ok1 := secret.Data["tls.crt"]
+	keyData, ok2 := secret.Data["tls.key"]
+	caCertData, ok3 := secret.Data["ca.crt"]
+	if !ok1 || !ok2 || !ok3 {
+		return fmt.Errorf("secret %s missing tls.crt, tls.key or ca.crt", secret.Name)
+	}
+
+	if err := os.MkdirAll(b.OutputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output dir: %w", err)
+	}
+
+	// renderConfig can materialize Secret-derived files (e.g. the DERP
+	// private key) into OutputDir via the secretFile template func, so
+	// it must run after OutputDir exists.
+	configYAML, err := b.renderConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to render config.yml: %w", err)
+	}
+
+	acl, err := b.renderACL(ctx, aclOverride)
+	if err != nil {
+		return err
+	}
+
+	certPath := filepath.Join(b.OutputDir, "tls.crt")
+	keyPath := filepath.Join(b.OutputDir, "tls.key")
+	caCertPath := filepath.Join(b.OutputDir, "ca.crt")
+
+	if err := writeFileAtomic(certPath, certData); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(keyPath, keyData); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(caCertPath, caCertData); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filepath.Join(b.OutputDir, "config.yml"), configYAML); err != nil {
+		return err
+	}
+
+	aclJSON, err := json.MarshalIndent(acl, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode acl.json: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(b.OutputDir, "acl.json"), aclJSON); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// renderConfig renders every entry in ConfigTemplatePaths/ConfigConfigMaps
+// in order through the TemplateRenderer, decodes each result as YAML,
+// and deep-merges them left-to-right so a later source overlays the
+// earlier ones, then re-encodes the merged document.
+func (b *Bootstrap) renderConfig(ctx context.Context) ([]byte, error) {
+	merged := map[string]interface{}{}
+
+	for _, path := range b.ConfigTemplatePaths {
+		text, err := loadConfigTemplate(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.mergeRenderedConfig(ctx, path, text, merged); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for _, ref := range b.ConfigConfigMaps {
+		text, err := fetchConfigMapKey(ctx, b.Client, ref)
+		if err != nil {
+			return nil, err
+		}
+		source := fmt.Sprintf("%s/%s/%s", ref.Namespace, ref.Name, ref.Key)
+		if err := b.mergeRenderedConfig(ctx, source, text, merged); err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+	}
+
+	return yaml.Marshal(merged)
+}
+
+// mergeRenderedConfig renders templateText and merges it into merged.
+// source identifies where templateText came from (its path, or
+// "namespace/name/key" for a ConfigMap) and is threaded through to
+// Render so randPassword can key its persisted value off the source
+// it was called from, not off call order within a single Render.
+func (b *Bootstrap) mergeRenderedConfig(ctx context.Context, source, templateText string, merged map[string]interface{}) error {
+	rendered, err := b.Renderer.Render(ctx, source, templateText)
+	if err != nil {
+		return err
+	}
+	doc, err := decodeYAMLDoc(rendered)
+	if err != nil {
+		return err
+	}
+	deepMergeYAML(merged, doc)
+	return nil
+}
+
+// renderACL reads every entry in ACLPaths/ACLConfigMaps (HuJSON or
+// plain JSON) and concatenates their acls/groups/tagOwners/hosts
+// sections, then merges aclOverride -- if present -- on top.
+func (b *Bootstrap) renderACL(ctx context.Context, aclOverride []byte) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+
+	for _, path := range b.ACLPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		doc, err := decodeHuJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		mergeACL(merged, doc)
+	}
+	for _, ref := range b.ACLConfigMaps {
+		data, err := fetchConfigMapKey(ctx, b.Client, ref)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := decodeHuJSON([]byte(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", ref.Namespace, ref.Name, err)
+		}
+		mergeACL(merged, doc)
+	}
+	if aclOverride != nil {
+		doc, err := decodeHuJSON(aclOverride)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode ACL ConfigMap contents: %w", err)
+		}
+		mergeACL(merged, doc)
+	}
+
+	return merged, nil
+}
+
+// loadConfigTemplate reads the user-supplied config.yml.tmpl, falling
+// back to builtinConfigTemplate when no template is mounted at path so
+// the default flow keeps rendering the config it always has.
+func loadConfigTemplate(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return builtinConfigTemplate, nil
+		}
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+// writeFileAtomic writes to a temp file in the same directory and
+// renames it into place, so Headscale never observes a partially
+// written config/cert file mid-reload.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", tmp, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ConfigMapRef points at a single key in a ConfigMap, parsed out of
+// the "namespace/name" or "namespace/name/key" entries in
+// HEADSCALE_CONFIG_CONFIGMAPS / HEADSCALE_ACL_CONFIGMAPS.
+type ConfigMapRef struct {
+	Namespace string
+	Name      string
+	Key       string
+}
+
+// parsePathList splits a colon-separated list of file paths (the
+// PATH/KUBECONFIG convention), dropping empty entries.
+func parsePathList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ":") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// parseConfigMapRefs splits a comma-separated list of
+// "namespace/name" or "namespace/name/key" entries, defaulting the
+// key to defaultKey when omitted.
+func parseConfigMapRefs(v, defaultKey string) ([]ConfigMapRef, error) {
+	var refs []ConfigMapRef
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "/", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid configmap reference %q, want namespace/name[/key]", entry)
+		}
+		ref := ConfigMapRef{Namespace: parts[0], Name: parts[1], Key: defaultKey}
+		if len(parts) == 3 {
+			ref.Key = parts[2]
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func fetchConfigMapKey(ctx context.Context, client kubernetes.Interface, ref ConfigMapRef) (string, error) {
+	cm, err := client.CoreV1().ConfigMaps(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get configmap %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	data, ok := cm.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("configmap %s/%s has no key %q", ref.Namespace, ref.Name, ref.Key)
+	}
+	return data, nil
+}
+
+// deepMergeYAML merges src into dst in place and returns it: maps are
+// merged key-by-key recursively, anything else (scalars, arrays) in
+// src overrides the value in dst. This is what lets a later source in
+// HEADSCALE_CONFIG_PATHS act as an overlay on an earlier one.
+func deepMergeYAML(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = map[string]interface{}{}
+	}
+	for k, srcVal := range src {
+		dstVal, exists := dst[k]
+		if !exists {
+			dst[k] = srcVal
+			continue
+		}
+		dstMap, dstIsMap := asStringMap(dstVal)
+		srcMap, srcIsMap := asStringMap(srcVal)
+		if dstIsMap && srcIsMap {
+			dst[k] = deepMergeYAML(dstMap, srcMap)
+		} else {
+			dst[k] = srcVal
+		}
+	}
+	return dst
+}
+
+// asStringMap normalizes the two shapes yaml.v3 decodes mappings into
+// (map[string]interface{} and map[interface{}]interface{}) into a
+// single map[string]interface{}, so deepMergeYAML only has to handle
+// one map type.
+func asStringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			out[fmt.Sprintf("%v", k)] = val
+		}
+		return out, true
+	default:
+		return nil, false
+	}
+}
+
+// decodeYAMLDoc decodes a single YAML document into a
+// map[string]interface{}, tolerating an empty document.
+func decodeYAMLDoc(data []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode YAML: %w", err)
+	}
+	return doc, nil
+}
+
+// mergeACL concatenates the shared Tailscale/Headscale ACL sections
+// (acls, groups, tagOwners, hosts) across src into dst instead of
+// overriding them wholesale, so a per-tenant overlay can add to a base
+// policy rather than replace it. Any other top-level key is
+// last-source-wins, same as deepMergeYAML.
+func mergeACL(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = map[string]interface{}{}
+	}
+	for k, srcVal := range src {
+		switch k {
+		case "acls", "hosts":
+			dst[k] = mergeACLSlice(dst[k], srcVal)
+		case "groups", "tagOwners":
+			dst[k] = mergeACLMap(dst[k], srcVal)
+		default:
+			dst[k] = srcVal
+		}
+	}
+	return dst
+}
+
+func mergeACLSlice(dstVal, srcVal interface{}) interface{} {
+	if srcSlice, ok := srcVal.([]interface{}); ok {
+		dstSlice, _ := dstVal.([]interface{})
+		return append(dstSlice, srcSlice...)
+	}
+	if srcMap, ok := asStringMap(srcVal); ok {
+		return mergeACLMap(dstVal, srcMap)
+	}
+	return srcVal
+}
+
+func mergeACLMap(dstVal, srcVal interface{}) interface{} {
+	srcMap, ok := asStringMap(srcVal)
+	if !ok {
+		return srcVal
+	}
+	dstMap, _ := asStringMap(dstVal)
+	if dstMap == nil {
+		dstMap = map[string]interface{}{}
+	}
+	for k, v := range srcMap {
+		if existing, ok := dstMap[k].([]interface{}); ok {
+			if add, ok := v.([]interface{}); ok {
+				dstMap[k] = append(existing, add...)
+				continue
+			}
+		}
+		dstMap[k] = v
+	}
+	return dstMap
+}
+
+// decodeHuJSON strips the `//` and `/* */` comments and trailing
+// commas HuJSON allows on top of JSON, then decodes the result with
+// the standard library -- enough to read Tailscale-style ACL files
+// without pulling in a HuJSON dependency.
+func decodeHuJSON(data []byte) (map[string]interface{}, error) {
+	stripped := stripHuJSONExtras(data)
+	var doc map[string]interface{}
+	if err := json.Unmarshal(stripped, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode ACL (HuJSON): %w", err)
+	}
+	return doc, nil
+}
+
+func stripHuJSONExtras(data []byte) []byte {
+	var out bytes.Buffer
+	inString := false
+	inLineComment := false
+	inBlockComment := false
+
+	for i := 0; i < len(data); i++ {
+		b := data[i]
+
+		if inLineComment {
+			if b == '\n' {
+				inLineComment = false
+				out.WriteByte(b)
+			}
+			continue
+		}
+		if inBlockComment {
+			if b == '*' && i+1 < len(data) && data[i+1] == '/' {
+				inBlockComment = false
+				i++
+			}
+			continue
+		}
+		if inString {
+			out.WriteByte(b)
+			if b == '\\' && i+1 < len(data) {
+				out.WriteByte(data[i+1])
+				i++
+				continue
+			}
+			if b == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case b == '"':
+			inString = true
+			out.WriteByte(b)
+		case b == '/' && i+1 < len(data) && data[i+1] == '/':
+			inLineComment = true
+			i++
+		case b == '/' && i+1 < len(data) && data[i+1] == '*':
+			inBlockComment = true
+			i++
+		case b == ',' && nextNonSpaceIsCloser(data, i+1):
+			// drop trailing comma
+		default:
+			out.WriteByte(b)
+		}
+	}
+	return out.Bytes()
+}
+
+func nextNonSpaceIsCloser(data []byte, i int) bool {
+	for ; i < len(data); i++ {
+		switch data[i] {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '}', ']':
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// builtinConfigTemplate reproduces the handful of keys setConfigFromEnv
+// used to hard-code, so clusters that don't mount a custom
+// config.yml.tmpl keep their current rendered config unchanged.
+const builtinConfigTemplate = `server_url: {{ required "HEADSCALE_SERVER_URL" }}
+listen_addr: 0.0.0.0:{{ urlPort (env "HEADSCALE_SERVER_URL") }}
+tls_cert_path: ./tls.crt
+tls_key_path: ./tls.key
+policy:
+  mode: file
+  path: ./acl.json
+{{- if env "HEADSCALE_LOG_LEVEL" }}
+log:
+  level: {{ env "HEADSCALE_LOG_LEVEL" }}
+{{- end }}
+{{- if env "HEADSCALE_OIDC_ISSUER" }}
+oidc:
+  issuer: {{ env "HEADSCALE_OIDC_ISSUER" }}
+  client_id: {{ env "HEADSCALE_OIDC_CLIENT_ID" }}
+  client_secret: {{ secret (env "OIDC_CLIENT_SECRET_REF") (envOrDefault "OIDC_CLIENT_SECRET_KEY" "client_secret") }}
+{{- end }}
+{{- if env "HEADSCALE_DERP_URLS" }}
+derp:
+  urls:
+{{- range splitList "," (env "HEADSCALE_DERP_URLS") }}
+    - {{ . }}
+{{- end }}
+{{- if env "HEADSCALE_DERP_PATHS" }}
+  paths:
+{{- range splitList "," (env "HEADSCALE_DERP_PATHS") }}
+    - {{ . }}
+{{- end }}
+{{- end }}
+{{- if env "DERP_SERVER_REGION_ID" }}
+  server:
+    enabled: true
+    region_id: {{ env "DERP_SERVER_REGION_ID" }}
+    region_code: {{ envOrDefault "DERP_SERVER_REGION_CODE" "skycluster" }}
+    region_name: {{ envOrDefault "DERP_SERVER_REGION_NAME" "SkyCluster" }}
+    stun_listen_addr: {{ envOrDefault "DERP_SERVER_STUN_LISTEN_ADDR" "0.0.0.0:3478" }}
+    private_key_path: {{ secretFile (env "DERP_PRIVATE_KEY_SECRET_REF") (envOrDefault "DERP_PRIVATE_KEY_SECRET_KEY" "private_key") "derp_server_private.key" }}
+{{- end }}
+{{- end }}
+{{- if env "HEADSCALE_DATABASE_TYPE" }}
+database:
+  type: {{ env "HEADSCALE_DATABASE_TYPE" }}
+{{- if eq (env "HEADSCALE_DATABASE_TYPE") "postgres" }}
+  postgres:
+    host: {{ env "HEADSCALE_DATABASE_POSTGRES_HOST" }}
+    port: {{ envOrDefault "HEADSCALE_DATABASE_POSTGRES_PORT" "5432" }}
+    name: {{ env "HEADSCALE_DATABASE_POSTGRES_NAME" }}
+    user: {{ env "HEADSCALE_DATABASE_POSTGRES_USER" }}
+    pass: {{ secret (env "DATABASE_POSTGRES_PASSWORD_SECRET_REF") (envOrDefault "DATABASE_POSTGRES_PASSWORD_SECRET_KEY" "password") }}
+{{- else }}
+  sqlite:
+    path: {{ envOrDefault "HEADSCALE_DATABASE_SQLITE_PATH" "/var/lib/headscale/db.sqlite" }}
+{{- end }}
+{{- end }}
+`
+
+// passwordCounterKeyPrefix namespaces the auto-generated persistence
+// keys randPassword assigns to each {{ randPassword N }} call, in the
+// order the template invokes them.
+const passwordCounterKeyPrefix = "password-"
+
+// TemplateRenderer executes a config.yml.tmpl (or the built-in
+// default) with a small function library so operators can drive the
+// full Headscale config from env vars and Kubernetes objects instead
+// of the fixed set of keys setConfigFromEnv used to hard-code.
+type TemplateRenderer struct {
+	client              kubernetes.Interface
+	namespace           string
+	generatedSecretName string
+	outputDir           string
+}
+
+func NewTemplateRenderer(client kubernetes.Interface, namespace, generatedSecretName, outputDir string) *TemplateRenderer {
+	return &TemplateRenderer{client: client, namespace: namespace, generatedSecretName: generatedSecretName, outputDir: outputDir}
+}
+
+// Render executes templateText (the contents of config.yml.tmpl, or
+// builtinConfigTemplate when no user template is mounted) and returns
+// the rendered config.yml bytes. source identifies where templateText
+// came from (a path, or "namespace/name/key" for a ConfigMap) and
+// namespaces the persistence keys randPassword generates, so the same
+// {{ randPassword N }} call in two different sources -- e.g. a base
+// config.yml.tmpl and a per-tenant overlay -- doesn't collide on a
+// single shared generated-Secret key.
+func (t *TemplateRenderer) Render(ctx context.Context, source, templateText string) ([]byte, error) {
+	tmpl, err := template.New("config").Funcs(t.funcMap(ctx, source)).Parse(templateText)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config template: %w", err)
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, fmt.Errorf("failed to render config template: %w", err)
+	}
+	return []byte(buf.String()), nil
+}
+
+func (t *TemplateRenderer) funcMap(ctx context.Context, source string) template.FuncMap {
+	passwordCount := 0
+	return template.FuncMap{
+		"env": os.Getenv,
+		"required": func(name string) (string, error) {
+			v := os.Getenv(name)
+			if v == "" {
+				return "", fmt.Errorf("%s not set", name)
+			}
+			return v, nil
+		},
+		"urlPort": func(rawURL string) (string, error) {
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				return "", fmt.Errorf("invalid url %q: %w", rawURL, err)
+			}
+			return u.Port(), nil
+		},
+		"envOrDefault": envOrDefault,
+		"splitList":    splitList,
+		"secret":       t.secretValue(ctx),
+		"configmap":    t.configMapValue(ctx),
+		"secretFile":   t.secretFile(ctx),
+		"fileB64":      fileB64,
+		"randPassword": func(length int) (string, error) {
+			passwordCount++
+			key := fmt.Sprintf("%s%s-%d", passwordCounterKeyPrefix, source, passwordCount)
+			return t.randPassword(ctx, key, length)
+		},
+	}
+}
+
+// splitList splits s on sep, trimming whitespace and dropping empty
+// entries -- used by the builtin template to turn comma-separated env
+// vars like HEADSCALE_DERP_URLS into a YAML list.
+func splitList(sep, s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, sep) {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// secretValue reads key out of the Secret named ref ("name" or
+// "namespace/name").
+func (t *TemplateRenderer) secretValue(ctx context.Context) func(ref, key string) (string, error) {
+	return func(ref, key string) (string, error) {
+		ns, name := t.splitRef(ref)
+		secret, err := t.client.CoreV1().Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("secret %s/%s: %w", ns, name, err)
+		}
+		v, ok := secret.Data[key]
+		if !ok {
+			return "", fmt.Errorf("secret %s/%s has no key %q", ns, name, key)
+		}
+		return string(v), nil
+	}
+}
+
+// configMapValue reads key out of the ConfigMap named ref.
+func (t *TemplateRenderer) configMapValue(ctx context.Context) func(ref, key string) (string, error) {
+	return func(ref, key string) (string, error) {
+		ns, name := t.splitRef(ref)
+		cm, err := t.client.CoreV1().ConfigMaps(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("configmap %s/%s: %w", ns, name, err)
+		}
+		v, ok := cm.Data[key]
+		if !ok {
+			return "", fmt.Errorf("configmap %s/%s has no key %q", ns, name, key)
+		}
+		return v, nil
+	}
+}
+
+// secretFile fetches key out of the Secret named ref and materializes
+// it as filename under the renderer's output directory, returning its
+// path relative to that directory. Some Headscale config keys (like
+// derp.server.private_key_path) want a file on disk rather than an
+// inline value.
+func (t *TemplateRenderer) secretFile(ctx context.Context) func(ref, key, filename string) (string, error) {
+	return func(ref, key, filename string) (string, error) {
+		if ref == "" {
+			return "", fmt.Errorf("secretFile: empty secret ref")
+		}
+		value, err := t.secretValue(ctx)(ref, key)
+		if err != nil {
+			return "", err
+		}
+		if err := writeFileAtomic(filepath.Join(t.outputDir, filename), []byte(value)); err != nil {
+			return "", err
+		}
+		return "./" + filename, nil
+	}
+}
+
+func (t *TemplateRenderer) splitRef(ref string) (namespace, name string) {
+	if parts := strings.SplitN(ref, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return t.namespace, ref
+}
+
+func fileB64(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// randPassword returns a random alphanumeric password of the given
+// length, generating and persisting it into generatedSecretName under
+// key on first use so it survives restarts.
+func (t *TemplateRenderer) randPassword(ctx context.Context, key string, length int) (string, error) {
+	secretsClient := t.client.CoreV1().Secrets(t.namespace)
+
+	existing, err := secretsClient.Get(ctx, t.generatedSecretName, metav1.GetOptions{})
+	notFound := errors.IsNotFound(err)
+	if err != nil && !notFound {
+		return "", fmt.Errorf("failed to get generated-secret %s: %w", t.generatedSecretName, err)
+	}
+	if err == nil {
+		if v, ok := existing.Data[key]; ok {
+			return string(v), nil
+		}
+	}
+
+	password, err := generatePassword(length)
+	if err != nil {
+		return "", err
+	}
+
+	if notFound {
+		existing = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: t.generatedSecretName, Namespace: t.namespace},
+			Data:       map[string][]byte{},
+		}
+	}
+	if existing.Data == nil {
+		existing.Data = map[string][]byte{}
+	}
+	existing.Data[key] = []byte(password)
+
+	if notFound {
+		_, err = secretsClient.Create(ctx, existing, metav1.CreateOptions{})
+	} else {
+		_, err = secretsClient.Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to persist generated password %q: %w", key, err)
+	}
+	return password, nil
+}
+
+const passwordAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+func generatePassword(length int) (string, error) {
+	out := make([]byte, length)
+	for i := range out {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(passwordAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		out[i] = passwordAlphabet[n.Int64()]
+	}
+	return string(out), nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Job is one pluggable sync job run by the Scheduler on its own
+// interval. Each job scans the same set of labeled ConfigMaps but only
+// looks at the data key(s) relevant to it, so a malformed
+// "managed-k8s.yaml" in one ConfigMap cannot prevent FlavorsSyncJob from
+// publishing the flavors it found elsewhere.
+type Job interface {
+	Name() string
+	Interval() time.Duration
+	Run(ctx context.Context, cms []corev1.ConfigMap) JobResult
+}
+
+// JobResult is what a Job produces for one run. ParseErrors are
+// per-ConfigMap problems that should surface as Kubernetes Events
+// rather than aborting the whole sync loop.
+type JobResult struct {
+	Entries     []vServiceStruct
+	ParseErrors []string
+}
+
+// FlavorsSyncJob extracts ComputeProfile vServices out of the
+// "flavors.yaml" key of each labeled ConfigMap.
+type FlavorsSyncJob struct {
+	interval time.Duration
+}
+
+func NewFlavorsSyncJob(interval time.Duration) *FlavorsSyncJob {
+	return &FlavorsSyncJob{interval: interval}
+}
+
+func (j *FlavorsSyncJob) Name() string { return "FlavorsSyncJob" }
+
+func (j *FlavorsSyncJob) Interval() time.Duration { return j.interval }
+
+func (j *FlavorsSyncJob) Run(ctx context.Context, cms []corev1.ConfigMap) JobResult {
+	var result JobResult
+	for _, cm := range cms {
+		cmData, ok := cm.Data["flavors.yaml"]
+		if !ok {
+			continue
+		}
+		pName, pPlatform, pRegion := providerLabels(cm)
+
+		var zoneOfferings []ZoneOfferings
+		if err := yaml.Unmarshal([]byte(cmData), &zoneOfferings); err != nil {
+			result.ParseErrors = append(result.ParseErrors, fmt.Sprintf("%s/%s: failed to unmarshal flavors.yaml: %v", cm.Namespace, cm.Name, err))
+			continue
+		}
+		for _, zo := range zoneOfferings {
+			for _, of := range zo.Offerings {
+				priceFloat, err := parseAmount(of.Price)
+				if err != nil {
+					result.ParseErrors = append(result.ParseErrors, fmt.Sprintf("%s/%s: failed to parse price for %s: %v", cm.Namespace, cm.Name, of.NameLabel, err))
+					continue
+				}
+				result.Entries = append(result.Entries, vServiceStruct{
+					VServiceName:     of.NameLabel,
+					VServiceKind:     "ComputeProfile",
+					ProviderName:     pName,
+					ProviderPlatform: pPlatform,
+					ProviderRegion:   pRegion,
+					ProviderZone:     zo.Zone,
+					DeployCost:       priceFloat,
+					Availability:     10000, // placeholder assumption
+				})
+			}
+		}
+	}
+	return result
+}
+
+// ManagedK8sSyncJob extracts ManagedKubernetes vServices out of the
+// "managed-k8s.yaml" key of each labeled ConfigMap.
+type ManagedK8sSyncJob struct {
+	interval time.Duration
+}
+
+func NewManagedK8sSyncJob(interval time.Duration) *ManagedK8sSyncJob {
+	return &ManagedK8sSyncJob{interval: interval}
+}
+
+func (j *ManagedK8sSyncJob) Name() string { return "ManagedK8sSyncJob" }
+
+func (j *ManagedK8sSyncJob) Interval() time.Duration { return j.interval }
+
+func (j *ManagedK8sSyncJob) Run(ctx context.Context, cms []corev1.ConfigMap) JobResult {
+	var result JobResult
+	for _, cm := range cms {
+		cmData, ok := cm.Data["managed-k8s.yaml"]
+		if !ok {
+			continue
+		}
+		pName, pPlatform, pRegion := providerLabels(cm)
+
+		var managedK8s []ManagedK8s
+		if err := yaml.Unmarshal([]byte(cmData), &managedK8s); err != nil {
+			result.ParseErrors = append(result.ParseErrors, fmt.Sprintf("%s/%s: failed to unmarshal managed-k8s.yaml: %v", cm.Namespace, cm.Name, err))
+			continue
+		}
+		for _, mk8s := range managedK8s {
+			priceFloat, err1 := parseAmount(mk8s.Price)
+			priceOverheadFloat, err2 := parseAmount(mk8s.Overhead.Cost)
+			if err1 != nil || err2 != nil {
+				result.ParseErrors = append(result.ParseErrors, fmt.Sprintf("%s/%s: failed to parse price or overhead for %s: price error: %v; overhead error: %v", cm.Namespace, cm.Name, mk8s.Name, err1, err2))
+				continue
+			}
+			result.Entries = append(result.Entries, vServiceStruct{
+				VServiceName:     mk8s.NameLabel,
+				VServiceKind:     "ManagedKubernetes",
+				ProviderName:     pName,
+				ProviderPlatform: pPlatform,
+				ProviderRegion:   pRegion,
+				DeployCost:       priceFloat + priceOverheadFloat,
+				Availability:     100000, // placeholder assumption
+			})
+		}
+	}
+	return result
+}
+
+// BaremetalWorkersSyncJob extracts one ComputeProfile vService per
+// baremetal device out of the "worker" key of each labeled ConfigMap.
+type BaremetalWorkersSyncJob struct {
+	interval time.Duration
+}
+
+func NewBaremetalWorkersSyncJob(interval time.Duration) *BaremetalWorkersSyncJob {
+	return &BaremetalWorkersSyncJob{interval: interval}
+}
+
+func (j *BaremetalWorkersSyncJob) Name() string { return "BaremetalWorkersSyncJob" }
+
+func (j *BaremetalWorkersSyncJob) Interval() time.Duration { return j.interval }
+
+func (j *BaremetalWorkersSyncJob) Run(ctx context.Context, cms []corev1.ConfigMap) JobResult {
+	var result JobResult
+	for _, cm := range cms {
+		cmData, ok := cm.Data["worker"]
+		if !ok {
+			continue
+		}
+		pName, pPlatform, pRegion := providerLabels(cm)
+
+		var workerSpecs map[string]DeviceZoneSpec
+		if err := yaml.Unmarshal([]byte(cmData), &workerSpecs); err != nil {
+			result.ParseErrors = append(result.ParseErrors, fmt.Sprintf("%s/%s: failed to unmarshal worker: %v", cm.Namespace, cm.Name, err))
+			continue
+		}
+		for devName, devSpec := range workerSpecs {
+			if devSpec.Configs == nil {
+				continue
+			}
+			priceFloat, err := parseAmount(devSpec.Configs.Price)
+			if err != nil {
+				result.ParseErrors = append(result.ParseErrors, fmt.Sprintf("%s/%s: failed to parse price for device %s: %v", cm.Namespace, cm.Name, devName, err))
+				continue
+			}
+			computeValueNormalized := NormalizeToTOPS(float64(devSpec.Configs.GPU.Count), devSpec.Configs.GPU.Unit)
+			devNameLabel := fmt.Sprintf("%dvCPU-%s-%dxTOPS", devSpec.Configs.VCPUs, devSpec.Configs.RAM, int(computeValueNormalized))
+			result.Entries = append(result.Entries, vServiceStruct{
+				VServiceName:     devNameLabel,
+				VServiceKind:     "ComputeProfile",
+				ProviderName:     pName,
+				ProviderPlatform: pPlatform,
+				ProviderRegion:   pRegion,
+				ProviderZone:     devSpec.Zone,
+				DeployCost:       priceFloat,
+				Availability:     1, // one device
+			})
+		}
+	}
+	return result
+}
+
+func providerLabels(cm corev1.ConfigMap) (name, platform, region string) {
+	return cm.Labels["skycluster.io/provider-profile"], cm.Labels["skycluster.io/provider-platform"], cm.Labels["skycluster.io/provider-region"]
+}
+
+// listProviderConfigMaps lists the ConfigMaps a Job should scan. It is
+// shared by all jobs so they agree on the label selector and namespace
+// scope the Scheduler was configured with.
+func listProviderConfigMaps(ctx context.Context, client kubernetes.Interface, namespace, labelSelector string) ([]corev1.ConfigMap, error) {
+	cmList, err := client.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, err
+	}
+	return cmList.Items, nil
+}
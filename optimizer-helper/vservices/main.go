@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+)
+
+func main() {
+	// LABEL_SELECTOR env var optional override for the configmap label selector.
+	// Default uses the two labels from the original one-shot job.
+	labelSelector := os.Getenv("LABEL_SELECTOR")
+	if labelSelector == "" {
+		labelSelector = "skycluster.io/config-type=provider-profile,skycluster.io/managed-by=skycluster"
+	}
+
+	// NAMESPACE env var optional: if empty, list across all namespaces.
+	namespace := os.Getenv("NAMESPACE")
+
+	// OUTPUT_NAMESPACE is where the catalog ConfigMap and warning
+	// Events are written. Unlike NAMESPACE it can't default to "list
+	// across all namespaces" -- Create/Update need one concrete
+	// namespace -- so fall back to the downward-API POD_NAMESPACE, the
+	// way ca-gen/main.go does, and then a fixed default.
+	outputNamespace := os.Getenv("OUTPUT_NAMESPACE")
+	if outputNamespace == "" {
+		outputNamespace = os.Getenv("POD_NAMESPACE")
+	}
+	if outputNamespace == "" {
+		outputNamespace = "skycluster-system"
+	}
+
+	outputConfigMap := os.Getenv("OUTPUT_CONFIGMAP")
+	if outputConfigMap == "" {
+		outputConfigMap = "skycluster-vservice-catalog"
+	}
+
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatalf("failed to get in-cluster config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		log.Fatalf("failed to create kubernetes clientset: %v", err)
+	}
+
+	recorder := newEventRecorder(clientset, outputNamespace)
+
+	scheduler := NewScheduler(clientset, namespace, outputNamespace, labelSelector, outputConfigMap, recorder,
+		NewFlavorsSyncJob(syncInterval("FLAVORS_SYNC_INTERVAL", 5*time.Minute)),
+		NewManagedK8sSyncJob(syncInterval("MANAGED_K8S_SYNC_INTERVAL", 5*time.Minute)),
+		NewBaremetalWorkersSyncJob(syncInterval("BAREMETAL_WORKERS_SYNC_INTERVAL", 5*time.Minute)),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		log.Println("shutting down")
+		cancel()
+	}()
+
+	if err := scheduler.Run(ctx); err != nil {
+		log.Fatalf("scheduler exited: %v", err)
+	}
+}
+
+func syncInterval(envVar string, def time.Duration) time.Duration {
+	v := os.Getenv(envVar)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v", envVar, v, err)
+	}
+	return d
+}
+
+func newEventRecorder(client kubernetes.Interface, namespace string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events(namespace)})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "vservices-scheduler"})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// debounce coalesces bursts of ConfigMap events (e.g. a batch apply of
+// several provider-profile ConfigMaps) into a single re-run.
+const debounce = 2 * time.Second
+
+// JobStatus mirrors the per-job reporting the request asked for:
+// LastSyncTime, ParseErrors and EntriesCount, published alongside the
+// aggregated catalog.
+type JobStatus struct {
+	Name         string    `json:"name"`
+	LastSyncTime time.Time `json:"lastSyncTime"`
+	ParseErrors  []string  `json:"parseErrors,omitempty"`
+	EntriesCount int       `json:"entriesCount"`
+}
+
+// Scheduler owns the set of Jobs, a shared informer over labeled
+// ConfigMaps that triggers event-driven re-runs, and publishes the
+// aggregated []vServiceStruct plus per-job status into a well-known
+// ConfigMap instead of a local file.
+type Scheduler struct {
+	client          kubernetes.Interface
+	namespace       string
+	outputNamespace string
+	labelSelector   string
+	outputCM        string
+	recorder        record.EventRecorder
+
+	jobs []Job
+
+	mu      sync.Mutex
+	results map[string][]vServiceStruct
+	status  map[string]JobStatus
+}
+
+func NewScheduler(client kubernetes.Interface, namespace, outputNamespace, labelSelector, outputCM string, recorder record.EventRecorder, jobs ...Job) *Scheduler {
+	return &Scheduler{
+		client:          client,
+		namespace:       namespace,
+		outputNamespace: outputNamespace,
+		labelSelector:   labelSelector,
+		outputCM:        outputCM,
+		recorder:        recorder,
+		jobs:            jobs,
+		results:         map[string][]vServiceStruct{},
+		status:          map[string]JobStatus{},
+	}
+}
+
+// Run starts the informer and each job's ticker, and blocks until ctx is
+// cancelled.
+func (s *Scheduler) Run(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.client, 0,
+		informers.WithNamespace(s.namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = s.labelSelector
+		}),
+	)
+	informer := factory.Core().V1().ConfigMaps().Informer()
+
+	changed := make(chan struct{}, 1)
+	notify := func(interface{}) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    notify,
+		UpdateFunc: func(_, newObj interface{}) { notify(newObj) },
+		DeleteFunc: notify,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register configmap handler: %w", err)
+	}
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	// Run once at startup so the catalog is populated before the first
+	// event or tick arrives.
+	s.runAll(ctx)
+
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		job := job
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runJobOnInterval(ctx, job)
+		}()
+	}
+
+	go s.debounceLoop(ctx, changed)
+
+	wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) debounceLoop(ctx context.Context, changed <-chan struct{}) {
+	var timer *time.Timer
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(debounce, func() { s.runAll(ctx) })
+		}
+	}
+}
+
+func (s *Scheduler) runJobOnInterval(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.runJob(ctx, job)
+			s.publish(ctx)
+		}
+	}
+}
+
+func (s *Scheduler) runAll(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.runJob(ctx, job)
+	}
+	s.publish(ctx)
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job Job) {
+	cms, err := listProviderConfigMaps(ctx, s.client, s.namespace, s.labelSelector)
+	if err != nil {
+		log.Printf("%s: failed to list configmaps: %v", job.Name(), err)
+		s.emitWarning("ListFailed", fmt.Sprintf("%s: failed to list provider-profile configmaps: %v", job.Name(), err))
+		return
+	}
+
+	result := job.Run(ctx, cms)
+	for _, msg := range result.ParseErrors {
+		log.Printf("%s: %s", job.Name(), msg)
+		s.emitWarning("ParseError", fmt.Sprintf("%s: %s", job.Name(), msg))
+	}
+
+	s.mu.Lock()
+	s.results[job.Name()] = result.Entries
+	s.status[job.Name()] = JobStatus{
+		Name:         job.Name(),
+		LastSyncTime: time.Now(),
+		ParseErrors:  result.ParseErrors,
+		EntriesCount: len(result.Entries),
+	}
+	s.mu.Unlock()
+}
+
+func (s *Scheduler) emitWarning(reason, message string) {
+	if s.recorder == nil {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		Kind:      "ConfigMap",
+		Namespace: s.outputNamespace,
+		Name:      s.outputCM,
+	}
+	s.recorder.Event(ref, corev1.EventTypeWarning, reason, message)
+}
+
+// publish aggregates every job's latest results and writes them, plus
+// per-job JobStatus, into the catalog ConfigMap.
+func (s *Scheduler) publish(ctx context.Context) {
+	s.mu.Lock()
+	var all []vServiceStruct
+	statuses := make([]JobStatus, 0, len(s.status))
+	for _, job := range s.jobs {
+		all = append(all, s.results[job.Name()]...)
+		statuses = append(statuses, s.status[job.Name()])
+	}
+	s.mu.Unlock()
+
+	catalogJSON, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		log.Printf("failed to marshal vservice catalog: %v", err)
+		return
+	}
+	statusJSON, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		log.Printf("failed to marshal job status: %v", err)
+		return
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.outputCM,
+			Namespace: s.outputNamespace,
+			Labels: map[string]string{
+				"skycluster.io/config-type": "vservice-catalog",
+				"skycluster.io/managed-by":  "skycluster",
+			},
+		},
+		Data: map[string]string{
+			"vservices.json": string(catalogJSON),
+			"status.json":    string(statusJSON),
+		},
+	}
+
+	cms := s.client.CoreV1().ConfigMaps(s.outputNamespace)
+	if existing, err := cms.Get(ctx, s.outputCM, metav1.GetOptions{}); err == nil {
+		cm.ResourceVersion = existing.ResourceVersion
+		if _, err := cms.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+			log.Printf("failed to update vservice catalog configmap: %v", err)
+		}
+		return
+	}
+	if _, err := cms.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+		log.Printf("failed to create vservice catalog configmap: %v", err)
+	}
+}
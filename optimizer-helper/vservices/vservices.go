@@ -1,18 +1,9 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
-
-	"gopkg.in/yaml.v3"
-
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
 type vServiceStruct struct {
@@ -76,166 +67,6 @@ type DeviceZoneSpec struct {
 	Configs   *InstanceOffering   `json:"configs,omitempty" yaml:"configs,omitempty"`
 }
 
-func main() {
-  ctx := context.Background()
-
-  // OUTPUT_PATH env var controls where to write the JSON file inside the pod.
-  // Default is /tmp/vservices.json (placeholder; change as needed).
-  outputPath := os.Getenv("OUTPUT_PATH")
-  if outputPath == "" {
-    outputPath = "/tmp/vservices.json" // placeholder default
-  }
-
-  // LABEL_SELECTOR env var optional override for the configmap label selector.
-  // Default uses the two labels from the snippet.
-  labelSelector := os.Getenv("LABEL_SELECTOR")
-  if labelSelector == "" {
-    labelSelector = "skycluster.io/config-type=provider-profile,skycluster.io/managed-by=skycluster"
-  }
-
-  // NAMESPACE env var optional: if empty, list across all namespaces.
-  // To restrict to a single namespace, set NAMESPACE=<your-namespace>.
-  namespace := os.Getenv("NAMESPACE") // empty => all namespaces
-
-  // In-cluster config
-  cfg, err := rest.InClusterConfig()
-  if err != nil {
-    fmt.Fprintf(os.Stderr, "failed to get in-cluster config: %v\n", err)
-    os.Exit(2)
-  }
-
-  clientset, err := kubernetes.NewForConfig(cfg)
-  if err != nil {
-    fmt.Fprintf(os.Stderr, "failed to create kubernetes clientset: %v\n", err)
-    os.Exit(2)
-  }
-
-  listOptions := metav1.ListOptions{
-    LabelSelector: labelSelector,
-  }
-
-  var vServicesList []vServiceStruct
-
-  // List ConfigMaps (namespace == "" => all namespaces)
-  cmList, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, listOptions)
-  if err != nil {
-    fmt.Fprintf(os.Stderr, "failed to list configmaps: %v\n", err)
-    os.Exit(2)
-  }
-
-  for _, cm := range cmList.Items {
-    pName := cm.Labels["skycluster.io/provider-profile"]
-    pPlatform := cm.Labels["skycluster.io/provider-platform"]
-    pRegion := cm.Labels["skycluster.io/provider-region"]
-    fmt.Printf("Processing configmap %s/%s\n", cm.Namespace, cm.Name)
-
-    // flavors.yaml: best-effort parsing (ignore errors as in original snippet)
-    if cmData, ok := cm.Data["flavors.yaml"]; ok {
-      var zoneOfferings []ZoneOfferings
-      if err := yaml.Unmarshal([]byte(cmData), &zoneOfferings); err == nil {
-        fmt.Printf("parsed flavors.yaml in configmap %s/%s with %d zone offerings\n", cm.Namespace, cm.Name, len(zoneOfferings))
-        for _, zo := range zoneOfferings {
-          fmt.Printf("  found zone offering: %s, %d offerings\n", zo.Zone, len(zo.Offerings))
-          for _, of := range zo.Offerings {
-            priceFloat, err := parseAmount(of.Price)
-            if err != nil {
-              fmt.Fprintf(os.Stderr, "failed to parse price for vservice %s in configmap %s/%s: %v\n", of.NameLabel, cm.Namespace, cm.Name, err)
-              continue // skip invalid price
-            }
-            vServicesList = append(vServicesList, vServiceStruct{
-              VServiceName:     of.NameLabel,
-              VServiceKind:     "ComputeProfile",
-              ProviderName:     pName,
-              ProviderPlatform: pPlatform,
-              ProviderRegion:   pRegion,
-              ProviderZone:     zo.Zone,
-              DeployCost:       priceFloat,
-              Availability:     10000, // placeholder assumption
-            })
-          }
-        }
-      } else {
-        // best-effort: log and continue
-        fmt.Fprintf(os.Stderr, "warning: failed to unmarshal flavors.yaml in configmap %s/%s: %v\n", cm.Namespace, cm.Name, err)
-      }
-    }
-
-    // managed-k8s.yaml: treat parse errors as fatal (mirrors original snippet)
-    if cmData, ok := cm.Data["managed-k8s.yaml"]; ok {
-      var managedK8s []ManagedK8s
-      if err := yaml.Unmarshal([]byte(cmData), &managedK8s); err != nil {
-        fmt.Fprintf(os.Stderr, "failed to unmarshal managed-k8s config map %s/%s: %v\n", cm.Namespace, cm.Name, err)
-        os.Exit(2)
-      }
-      fmt.Println("parsed managed-k8s.yaml with", len(managedK8s), "managed k8s offerings")
-      for _, mk8s := range managedK8s {
-        priceFloat, err1 := parseAmount(mk8s.Price)
-        priceOverheadFloat, err2 := parseAmount(mk8s.Overhead.Cost)
-        if err1 != nil || err2 != nil {
-          fmt.Fprintf(os.Stderr, "failed to parse price or overhead for managed k8s vservice %s in configmap %s/%s: price error: %v; overhead error: %v\n", mk8s.Name, cm.Namespace, cm.Name, err1, err2)
-          os.Exit(2)
-        }
-        vServicesList = append(vServicesList, vServiceStruct{
-          VServiceName:     mk8s.NameLabel,
-          VServiceKind:     "ManagedKubernetes",
-          ProviderName:     pName,
-          ProviderPlatform: pPlatform,
-          ProviderRegion:   pRegion,
-          DeployCost:       priceFloat + priceOverheadFloat,
-          Availability:     100000, // placeholder assumption
-        })
-      }
-    }
-
-    // baremetal workers
-    if cmData, ok := cm.Data["worker"]; ok {
-      var workerSpecs map[string]DeviceZoneSpec
-      if err := yaml.Unmarshal([]byte(cmData), &workerSpecs); err != nil {
-        fmt.Fprintf(os.Stderr, "failed to unmarshal workers config map %s/%s: %v\n", cm.Namespace, cm.Name, err)
-        os.Exit(2)
-      }
-      fmt.Printf("parsed workers in configmap %s/%s with %d device specs\n", cm.Namespace, cm.Name, len(workerSpecs))
-      for devName, devSpec := range workerSpecs {
-        if devSpec.Configs == nil {continue}
-        fmt.Printf(" Processing device %s\n", devName)
-        priceFloat, err := parseAmount(devSpec.Configs.Price)
-        if err != nil {
-          fmt.Fprintf(os.Stderr, "failed to parse price for vservice %s in configmap %s/%s: %v\n", devName, cm.Namespace, cm.Name, err)
-          continue // skip invalid price
-        }
-        computeValueNormalized := NormalizeToTOPS(float64(devSpec.Configs.GPU.Count), devSpec.Configs.GPU.Unit)
-        devNameLabel := fmt.Sprintf("%dvCPU-%s-%dxTOPS", devSpec.Configs.VCPUs, devSpec.Configs.RAM, int(computeValueNormalized))
-        fmt.Printf("  Device %s: %s\n", devName, devNameLabel)
-        vServicesList = append(vServicesList, vServiceStruct{
-          VServiceName:     devNameLabel,
-          VServiceKind:     "ComputeProfile",
-          ProviderName:     pName,
-          ProviderPlatform: pPlatform,
-          ProviderRegion:   pRegion,
-          ProviderZone:     devSpec.Zone,
-          DeployCost:       priceFloat,
-          Availability:     1, // one device
-        })
-      }
-    }
-  }
-
-  b, err := json.MarshalIndent(vServicesList, "", "  ")
-  if err != nil {
-    fmt.Fprintf(os.Stderr, "failed to marshal virtual services: %v\n", err)
-    os.Exit(2)
-  }
-
-  if err := os.WriteFile(outputPath, b, 0644); err != nil {
-    fmt.Fprintf(os.Stderr, "failed to write output file %s: %v\n", outputPath, err)
-    os.Exit(2)
-  }
-
-  fmt.Printf("wrote %d virtual services to %s\n", len(vServicesList), outputPath)
-}
-
-
-
 func parseAmount(s string) (float64, error) {
 	s = strings.TrimSpace(s)
 
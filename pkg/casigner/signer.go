@@ -0,0 +1,309 @@
+// Package casigner implements a Kubernetes CertificateSigningRequest
+// signer backed by the SkyCluster self-signed CA. It lets any workload
+// (kubelets, webhook servers, per-tenant vService proxies) request a
+// leaf certificate through the standard certificates.k8s.io API instead
+// of duplicating the ECDSA/x509 plumbing that used to live only in the
+// ca-signer one-shot job.
+package casigner
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	certsv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SignerName is the signerName this signer honors. CSRs with any other
+// signerName are ignored.
+const SignerName = "skycluster.io/self-ca"
+
+// SANAllowlistAnnotation is read off the requesting ServiceAccount. Its
+// value is a comma-separated list of "DNS:" / "IP:" entries (same
+// syntax as CERT_SANS); a CSR is denied if it asks for a SAN outside
+// this list.
+const SANAllowlistAnnotation = "skycluster.io/csr-san-allowlist"
+
+// Config seeds a Signer.
+type Config struct {
+	Namespace    string
+	CASecretName string
+	// MaxDuration bounds spec.expirationSeconds regardless of what the
+	// requester asked for.
+	MaxDuration time.Duration
+}
+
+// Signer watches CertificateSigningRequests with spec.signerName ==
+// SignerName, validates and signs them with the CA loaded from the
+// configured Secret, and writes the result back to status.certificate.
+type Signer struct {
+	client kubernetes.Interface
+	cfg    Config
+
+	factory informers.SharedInformerFactory
+}
+
+func New(client kubernetes.Interface, cfg Config) *Signer {
+	if cfg.MaxDuration == 0 {
+		cfg.MaxDuration = 24 * time.Hour
+	}
+	return &Signer{
+		client:  client,
+		cfg:     cfg,
+		factory: informers.NewSharedInformerFactory(client, 10*time.Minute),
+	}
+}
+
+// Run watches CSRs until ctx is cancelled.
+func (s *Signer) Run(ctx context.Context) error {
+	informer := s.factory.Certificates().V1().CertificateSigningRequests().Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.handle(ctx, obj) },
+		UpdateFunc: func(_, obj interface{}) { s.handle(ctx, obj) },
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register CSR handler: %w", err)
+	}
+
+	s.factory.Start(ctx.Done())
+	s.factory.WaitForCacheSync(ctx.Done())
+
+	<-ctx.Done()
+	return nil
+}
+
+func (s *Signer) handle(ctx context.Context, obj interface{}) {
+	csr, ok := obj.(*certsv1.CertificateSigningRequest)
+	if !ok || csr.Spec.SignerName != SignerName {
+		return
+	}
+	if csr.Status.Certificate != nil {
+		return // already signed
+	}
+	if isDenied(csr) || isApproved(csr) {
+		return // already decided (by us, or by an external approver)
+	}
+
+	// There is no approver for SignerName anywhere in the cluster, so
+	// this signer is also the approver: it approves a CSR itself once
+	// checkSANAllowlist passes, rather than waiting on a
+	// "kubectl certificate approve" that will never come.
+	if err := s.sign(ctx, csr); err != nil {
+		log.Printf("casigner: failed to sign CSR %s: %v", csr.Name, err)
+		s.deny(ctx, csr, "SigningFailed", err.Error())
+	}
+}
+
+func isApproved(csr *certsv1.CertificateSigningRequest) bool {
+	for _, c := range csr.Status.Conditions {
+		if c.Type == certsv1.CertificateApproved {
+			return true
+		}
+	}
+	return false
+}
+
+func isDenied(csr *certsv1.CertificateSigningRequest) bool {
+	for _, c := range csr.Status.Conditions {
+		if c.Type == certsv1.CertificateDenied {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Signer) sign(ctx context.Context, csr *certsv1.CertificateSigningRequest) error {
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return fmt.Errorf("spec.request does not contain a PEM CSR")
+	}
+	req, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse embedded CSR: %w", err)
+	}
+	if err := req.CheckSignature(); err != nil {
+		return fmt.Errorf("embedded CSR has an invalid signature: %w", err)
+	}
+
+	if err := s.checkSANAllowlist(ctx, csr.Spec.Username, req); err != nil {
+		s.deny(ctx, csr, "SANNotAllowed", err.Error())
+		return nil
+	}
+
+	caCert, caKey, err := s.loadCA(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load CA: %w", err)
+	}
+
+	duration := s.cfg.MaxDuration
+	if csr.Spec.ExpirationSeconds != nil {
+		requested := time.Duration(*csr.Spec.ExpirationSeconds) * time.Second
+		if requested < duration {
+			duration = requested
+		}
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: req.Subject.CommonName},
+		DNSNames:     req.DNSNames,
+		IPAddresses:  req.IPAddresses,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(duration),
+		KeyUsage:     keyUsageFromRequest(csr.Spec.Usages),
+		ExtKeyUsage:  extKeyUsageFromRequest(csr.Spec.Usages),
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, req.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("failed to sign certificate: %w", err)
+	}
+
+	csr = csr.DeepCopy()
+	csr.Status.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	csr.Status.Conditions = append(csr.Status.Conditions, certsv1.CertificateSigningRequestCondition{
+		Type:    certsv1.CertificateApproved,
+		Status:  "True",
+		Reason:  "SkyClusterCAIssued",
+		Message: "Signed by the skycluster self-ca signer",
+	})
+	_, err = s.client.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{})
+	return err
+}
+
+func (s *Signer) deny(ctx context.Context, csr *certsv1.CertificateSigningRequest, reason, message string) {
+	csr = csr.DeepCopy()
+	csr.Status.Conditions = append(csr.Status.Conditions, certsv1.CertificateSigningRequestCondition{
+		Type:    certsv1.CertificateDenied,
+		Status:  "True",
+		Reason:  reason,
+		Message: message,
+	})
+	if _, err := s.client.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{}); err != nil {
+		log.Printf("casigner: failed to deny CSR %s: %v", csr.Name, err)
+	}
+}
+
+// checkSANAllowlist rejects CSRs whose SANs are not covered by the
+// SANAllowlistAnnotation on the requesting ServiceAccount. username is
+// of the form "system:serviceaccount:<namespace>:<name>".
+func (s *Signer) checkSANAllowlist(ctx context.Context, username string, req *x509.CertificateRequest) error {
+	parts := strings.Split(username, ":")
+	if len(parts) != 4 || parts[0] != "system" || parts[1] != "serviceaccount" {
+		return fmt.Errorf("requester %q is not a ServiceAccount", username)
+	}
+	ns, name := parts[2], parts[3]
+
+	sa, err := s.client.CoreV1().ServiceAccounts(ns).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to look up requesting ServiceAccount %s/%s: %w", ns, name, err)
+	}
+	allowlist := sa.Annotations[SANAllowlistAnnotation]
+
+	allowedDNS, allowedIP := map[string]bool{}, map[string]bool{}
+	for _, entry := range strings.Split(allowlist, ",") {
+		entry = strings.TrimSpace(entry)
+		typ, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToUpper(strings.TrimSpace(typ)) {
+		case "DNS":
+			allowedDNS[strings.TrimSpace(value)] = true
+		case "IP":
+			allowedIP[strings.TrimSpace(value)] = true
+		}
+	}
+
+	for _, dns := range req.DNSNames {
+		if !allowedDNS[dns] {
+			return fmt.Errorf("DNS SAN %q is not covered by the %s annotation on %s/%s", dns, SANAllowlistAnnotation, ns, name)
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if !allowedIP[ip.String()] {
+			return fmt.Errorf("IP SAN %q is not covered by the %s annotation on %s/%s", ip.String(), SANAllowlistAnnotation, ns, name)
+		}
+	}
+	return nil
+}
+
+// loadCA fetches the CA cert/key pair written by ca-gen into
+// skycluster-self-ca (ca.crt/ca.key, RSA, matching generateCA in the
+// ca-gen program).
+func (s *Signer) loadCA(ctx context.Context) (*x509.Certificate, crypto.Signer, error) {
+	secret, err := s.client.CoreV1().Secrets(s.cfg.Namespace).Get(ctx, s.cfg.CASecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(secret.Data["ca.crt"])
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("failed to decode ca.crt in %s/%s", s.cfg.Namespace, s.cfg.CASecretName)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBlock, _ := pem.Decode(secret.Data["ca.key"])
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("failed to decode ca.key in %s/%s", s.cfg.Namespace, s.cfg.CASecretName)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func keyUsageFromRequest(usages []certsv1.KeyUsage) x509.KeyUsage {
+	var ku x509.KeyUsage
+	for _, u := range usages {
+		switch u {
+		case certsv1.UsageDigitalSignature:
+			ku |= x509.KeyUsageDigitalSignature
+		case certsv1.UsageKeyEncipherment:
+			ku |= x509.KeyUsageKeyEncipherment
+		case certsv1.UsageCertSign:
+			ku |= x509.KeyUsageCertSign
+		}
+	}
+	if ku == 0 {
+		ku = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	}
+	return ku
+}
+
+func extKeyUsageFromRequest(usages []certsv1.KeyUsage) []x509.ExtKeyUsage {
+	var eku []x509.ExtKeyUsage
+	for _, u := range usages {
+		switch u {
+		case certsv1.UsageServerAuth:
+			eku = append(eku, x509.ExtKeyUsageServerAuth)
+		case certsv1.UsageClientAuth:
+			eku = append(eku, x509.ExtKeyUsageClientAuth)
+		}
+	}
+	if len(eku) == 0 {
+		eku = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	}
+	return eku
+}